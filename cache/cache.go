@@ -1,129 +1,584 @@
 package cache
 
 import (
+	"container/heap"
+	"container/list"
 	"context"
+	"fmt"
+	"hash/fnv"
 	"sync/atomic"
 	"time"
 )
 
-var (
-	hits    int64
-	misses  int64
-	evicted int64
-)
+// defaultShards is used whenever Config.Shards is zero or not a power of two.
+const defaultShards = 256
+
+// defaultCleanupPeriod is used whenever Config.CleanupPeriod is non-positive;
+// time.NewTicker panics on a non-positive interval, so every shard's cleanup
+// goroutine needs a sane period even if the caller didn't set one.
+const defaultCleanupPeriod = time.Minute
+
+// New constructs a Cache for the given key and value types.
+func New[K comparable, V any](config Config) *Cache[K, V] {
+	n := config.Shards
+	if n <= 0 || n&(n-1) != 0 {
+		n = defaultShards
+	}
+
+	cleanupPeriod := config.CleanupPeriod
+	if cleanupPeriod <= 0 {
+		cleanupPeriod = defaultCleanupPeriod
+	}
+
+	c := &Cache[K, V]{
+		shards:    make([]*shard[K, V], n),
+		shardMax:  uint64(n - 1),
+		callbacks: newCallbackRegistry[K, V](),
+	}
 
-func New(config Config) *Cache {
-	ctx, cancel := context.WithCancel(context.Background())
-	c := &Cache{
-		items:   make(map[CacheKey]CacheEntry),
-		maxSize: config.MaxSize,
-		ttl:     config.TTL,
-		ctx:     ctx,
-		cancel:  cancel,
+	for i := range c.shards {
+		ctx, cancel := context.WithCancel(context.Background())
+		s := &shard[K, V]{
+			items:   make(map[K]CacheEntry[V]),
+			maxSize: config.MaxSize,
+			ttl:     config.TTL,
+			sliding: config.SlidingExpiration,
+			policy:  config.EvictionPolicy,
+			ctx:     ctx,
+			cancel:  cancel,
+		}
+		s.order = list.New()
+		s.freqList = list.New()
+		c.shards[i] = s
+		go s.cleanup(cleanupPeriod, c.callbacks)
 	}
 
-	// Start cleanup routine
-	go c.cleanup(config.CleanupPeriod)
 	return c
 }
 
-func (c *Cache) Set(key CacheKey, value interface{}) {
-	c.Lock()
-	defer c.Unlock()
+// NewAny constructs an AnyCache, preserving the pre-generics API for callers
+// that aren't ready to specify concrete key/value types.
+func NewAny(config Config) *AnyCache {
+	return New[CacheKey, interface{}](config)
+}
+
+// OnEviction registers fn to be called whenever an entry is deleted, expires,
+// is evicted for capacity, or is overwritten by Set. Clear does not call it.
+// It returns an unsubscribe function. fn runs outside any shard lock, so it
+// may safely call back into the cache.
+func (c *Cache[K, V]) OnEviction(fn func(key K, value V, reason EvictionReason)) func() {
+	return c.callbacks.addEviction(fn)
+}
+
+// OnInsertion registers fn to be called whenever Set stores a value. It
+// returns an unsubscribe function. fn runs outside any shard lock, so it may
+// safely call back into the cache.
+func (c *Cache[K, V]) OnInsertion(fn func(key K, value V)) func() {
+	return c.callbacks.addInsertion(fn)
+}
+
+// shardFor hashes key with FNV-1a and returns the shard responsible for it.
+func (c *Cache[K, V]) shardFor(key K) *shard[K, V] {
+	return c.shards[hashKey(key)&c.shardMax]
+}
 
-	// Check if we need to evict
-	if len(c.items) >= c.maxSize {
-		c.evictOldest()
+// hashKey computes the FNV-1a hash of key. string, []byte, and CacheKey are
+// written to the hash directly; any other comparable type falls back to
+// fmt.Fprintf, which is correct but pays for reflection.
+func hashKey[K comparable](key K) uint64 {
+	h := fnv.New64a()
+	switch k := any(key).(type) {
+	case string:
+		h.Write([]byte(k))
+	case []byte:
+		h.Write(k)
+	case CacheKey:
+		h.Write([]byte(k))
+	default:
+		fmt.Fprintf(h, "%v", k)
 	}
+	return h.Sum64()
+}
+
+// Set stores value under key using the cache's default TTL.
+func (c *Cache[K, V]) Set(key K, value V) {
+	s := c.shardFor(key)
+	c.setTTL(s, key, value, s.ttl)
+}
+
+// SetWithTTL stores value under key with a per-entry TTL override, bypassing
+// the cache's default. Pass NoExpiration to pin the entry indefinitely.
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	s := c.shardFor(key)
+	c.setTTL(s, key, value, ttl)
+}
+
+func (c *Cache[K, V]) setTTL(s *shard[K, V], key K, value V, ttl time.Duration) {
+	s.Lock()
+
+	if entry, exists := s.items[key]; exists {
+		oldValue := entry.Value
+		entry.Value = value
+		entry.ttl = ttl
+		entry.version = s.nextVersion()
+		if ttl > 0 {
+			entry.Expiration = time.Now().Add(ttl)
+			s.pushExpiry(key, entry.Expiration, entry.version)
+		} else {
+			entry.Expiration = time.Time{}
+		}
+		s.items[key] = entry
+		s.Unlock()
 
-	c.items[key] = CacheEntry{
-		Value:      value,
-		Expiration: time.Now().Add(c.ttl),
+		c.callbacks.fireEviction(key, oldValue, ReasonReplaced)
+		c.callbacks.fireInsertion(key, value)
+		return
+	}
+
+	var evictedKey K
+	var evictedValue V
+	evicted := false
+	if len(s.items) >= s.maxSize {
+		evictedKey, evictedValue, evicted = s.evict()
+	}
+
+	entry := CacheEntry[V]{
+		Value:   value,
+		ttl:     ttl,
+		version: s.nextVersion(),
+	}
+	if ttl > 0 {
+		entry.Expiration = time.Now().Add(ttl)
+	}
+	switch s.policy {
+	case PolicySIEVE:
+		entry.visited = new(int32)
+		entry.elem = s.order.PushFront(key)
+	case PolicyLFU:
+		s.lfuInsert(key, &entry)
+	default: // PolicyLRU
+		entry.elem = s.order.PushFront(key)
+	}
+	s.items[key] = entry
+	if ttl > 0 {
+		s.pushExpiry(key, entry.Expiration, entry.version)
 	}
+	s.Unlock()
+
+	if evicted {
+		c.callbacks.fireEviction(evictedKey, evictedValue, ReasonCapacityReached)
+	}
+	c.callbacks.fireInsertion(key, value)
+}
+
+// Get looks up key. PolicyLRU's list move and PolicyLFU's frequency-bucket
+// move both mutate shared state and so need the write lock; PolicySIEVE
+// only flips an atomic visited bit and can run under the read lock instead
+// — unless sliding expiration is on, since that rewrites the entry's
+// Expiration in the map and needs the write lock regardless of policy.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	s := c.shardFor(key)
+
+	if s.policy == PolicyLRU || s.policy == PolicyLFU || s.sliding {
+		return s.getExclusive(key)
+	}
+	return s.getShared(key)
+}
+
+// getExclusive handles Get under the write lock: PolicyLRU's list move,
+// PolicyLFU's bucket move, and sliding expiration's Expiration rewrite all
+// need it.
+func (s *shard[K, V]) getExclusive(key K) (V, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	var zero V
+
+	entry, exists := s.items[key]
+	if !exists {
+		atomic.AddInt64(&s.misses, 1)
+		return zero, false
+	}
+
+	now := time.Now()
+	if entry.expired(now) {
+		atomic.AddInt64(&s.misses, 1)
+		return zero, false
+	}
+
+	dirty := false
+	switch s.policy {
+	case PolicyLRU:
+		s.order.MoveToFront(entry.elem)
+	case PolicyLFU:
+		s.lfuTouch(key, &entry)
+		dirty = true
+	default: // PolicySIEVE
+		if entry.visited != nil {
+			atomic.StoreInt32(entry.visited, 1)
+		}
+	}
+
+	if s.sliding && entry.ttl > 0 {
+		entry.Expiration = now.Add(entry.ttl)
+		entry.version = s.nextVersion()
+		s.pushExpiry(key, entry.Expiration, entry.version)
+		dirty = true
+	}
+
+	if dirty {
+		s.items[key] = entry
+	}
+
+	atomic.AddInt64(&s.hits, 1)
+	return entry.Value, true
 }
 
-func (c *Cache) Get(key CacheKey) (interface{}, bool) {
-	c.RLock()
-	defer c.RUnlock()
+// getShared handles Get under the read lock for policies that don't mutate
+// shared state on a hit: PolicySIEVE's visited bit is set with an atomic
+// store through a pointer already in the entry, so no map write is needed.
+func (s *shard[K, V]) getShared(key K) (V, bool) {
+	s.RLock()
+	defer s.RUnlock()
 
-	entry, exists := c.items[key]
+	var zero V
+
+	entry, exists := s.items[key]
 	if !exists {
-		atomic.AddInt64(&misses, 1)
-		return nil, false
+		atomic.AddInt64(&s.misses, 1)
+		return zero, false
+	}
+
+	if entry.expired(time.Now()) {
+		atomic.AddInt64(&s.misses, 1)
+		return zero, false
 	}
 
-	if time.Now().After(entry.Expiration) {
-		atomic.AddInt64(&misses, 1)
-		return nil, false
+	if entry.visited != nil {
+		atomic.StoreInt32(entry.visited, 1)
 	}
 
-	atomic.AddInt64(&hits, 1)
+	atomic.AddInt64(&s.hits, 1)
 	return entry.Value, true
 }
 
-func (c *Cache) Delete(key CacheKey) {
-	c.Lock()
-	defer c.Unlock()
-	delete(c.items, key)
+// GetOrLoad returns the cached value for key if present and unexpired.
+// Otherwise it calls loader, caches a successful result, and returns it.
+// Concurrent callers missing on the same key share a single loader call.
+func (c *Cache[K, V]) GetOrLoad(key K, loader func() (V, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+	return c.load(key, loader)
+}
+
+// Refresh invokes loader and caches its result even if key is currently
+// present, deduplicating concurrent callers the same way GetOrLoad does.
+// Use it to refresh a cache entry in the background without a stampede.
+func (c *Cache[K, V]) Refresh(key K, loader func() (V, error)) (V, error) {
+	return c.load(key, loader)
+}
+
+// load runs loader through key's shard's singleflight group so that N
+// concurrent callers for the same key trigger exactly one call. Keys on
+// different shards never share a group, so a stampede on one key can't
+// block callers loading an unrelated one.
+func (c *Cache[K, V]) load(key K, loader func() (V, error)) (V, error) {
+	s := c.shardFor(key)
+	sfKey := sfKeyFor(key)
+
+	result, err, _ := s.loadGroup.Do(sfKey, func() (interface{}, error) {
+		value, err := loader()
+		if err != nil {
+			return nil, err
+		}
+		c.Set(key, value)
+		return value, nil
+	})
+
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return result.(V), nil
+}
+
+// sfKeyFor builds the singleflight.Group key for key. string and CacheKey
+// take a zero-allocation fast path; any other comparable type falls back to
+// fmt.Sprintf, same as hashKey.
+func sfKeyFor[K comparable](key K) string {
+	switch k := any(key).(type) {
+	case string:
+		return k
+	case CacheKey:
+		return string(k)
+	default:
+		return fmt.Sprintf("%v", k)
+	}
 }
 
-func (c *Cache) Clear() {
-	c.Lock()
-	defer c.Unlock()
-	c.items = make(map[CacheKey]CacheEntry)
+func (c *Cache[K, V]) Delete(key K) {
+	s := c.shardFor(key)
+	s.Lock()
+	entry, existed := s.items[key]
+	s.removeLocked(key)
+	s.Unlock()
+
+	if existed {
+		c.callbacks.fireEviction(key, entry.Value, ReasonDeleted)
+	}
 }
 
-func (c *Cache) GetStats() CacheStats {
-	c.RLock()
-	defer c.RUnlock()
+func (c *Cache[K, V]) Clear() {
+	for _, s := range c.shards {
+		s.Lock()
+		s.items = make(map[K]CacheEntry[V])
+		s.order = list.New()
+		s.hand = nil
+		s.freqList = list.New()
+		s.expiry = nil
+		s.Unlock()
+	}
+}
 
-	return CacheStats{
-		Size:    len(c.items),
-		Hits:    atomic.LoadInt64(&hits),
-		Misses:  atomic.LoadInt64(&misses),
-		Evicted: atomic.LoadInt64(&evicted),
+func (c *Cache[K, V]) GetStats() CacheStats {
+	var stats CacheStats
+	for _, s := range c.shards {
+		s.RLock()
+		stats.Size += len(s.items)
+		stats.Hits += atomic.LoadInt64(&s.hits)
+		stats.Misses += atomic.LoadInt64(&s.misses)
+		stats.Evicted += atomic.LoadInt64(&s.evicted)
+		s.RUnlock()
 	}
+	return stats
 }
 
-func (c *Cache) cleanup(period time.Duration) {
+func (s *shard[K, V]) cleanup(period time.Duration, callbacks *callbackRegistry[K, V]) {
 	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			c.Lock()
-			now := time.Now()
-			for key, entry := range c.items {
-				if now.After(entry.Expiration) {
-					delete(c.items, key)
-					atomic.AddInt64(&evicted, 1)
-				}
+			s.Lock()
+			expiredKeys, expiredValues := s.collectExpiredLocked(time.Now())
+			s.Unlock()
+
+			for i, key := range expiredKeys {
+				callbacks.fireEviction(key, expiredValues[i], ReasonExpired)
 			}
-			c.Unlock()
-		case <-c.ctx.Done():
+		case <-s.ctx.Done():
 			return
 		}
 	}
 }
 
-func (c *Cache) evictOldest() {
-	var oldestKey CacheKey
-	var oldestTime time.Time
+// pushExpiry records that key is due to expire at expiration, tagged with
+// its entry's current version. Callers must hold s's write lock.
+func (s *shard[K, V]) pushExpiry(key K, expiration time.Time, version int64) {
+	heap.Push(&s.expiry, expiryItem[K]{key: key, expiration: expiration, version: version})
+}
+
+// nextVersion returns the shard's next monotonically increasing entry
+// version. Using a shard-wide sequence instead of letting each new entry
+// start over from zero means a key that's deleted and then re-Set never
+// collides with a stale heap item left behind by the deleted entry.
+// Callers must hold s's write lock.
+func (s *shard[K, V]) nextVersion() int64 {
+	s.versionSeq++
+	return s.versionSeq
+}
 
-	for key, entry := range c.items {
-		if oldestTime.IsZero() || entry.Expiration.Before(oldestTime) {
-			oldestKey = key
-			oldestTime = entry.Expiration
+// collectExpiredLocked pops due entries off the expiry heap in O(log n) per
+// entry, stopping as soon as the earliest remaining expiration is in the
+// future. Heap items whose version no longer matches the live entry (it was
+// overwritten, its TTL bumped, or it was already removed) are stale and
+// discarded without side effects. Callers must hold s's write lock.
+func (s *shard[K, V]) collectExpiredLocked(now time.Time) (keys []K, values []V) {
+	for s.expiry.Len() > 0 {
+		item := s.expiry[0]
+		if item.expiration.After(now) {
+			break
 		}
+		heap.Pop(&s.expiry)
+
+		entry, exists := s.items[item.key]
+		if !exists || entry.version != item.version {
+			continue
+		}
+
+		keys = append(keys, item.key)
+		values = append(values, entry.Value)
+		s.removeLocked(item.key)
+		atomic.AddInt64(&s.evicted, 1)
+	}
+	return keys, values
+}
+
+// removeLocked deletes key from the shard, unlinking it from whichever
+// structure its policy threads it through and advancing the SIEVE hand if
+// it pointed at the removed node. Callers must hold s's write lock.
+func (s *shard[K, V]) removeLocked(key K) {
+	entry, exists := s.items[key]
+	if !exists {
+		return
 	}
+	delete(s.items, key)
 
-	if !oldestTime.IsZero() {
-		delete(c.items, oldestKey)
-		atomic.AddInt64(&evicted, 1)
+	if s.policy == PolicyLFU {
+		if entry.freqElem != nil {
+			s.lfuRemove(entry)
+		}
+		return
 	}
+
+	if entry.elem == nil {
+		return
+	}
+	if s.hand == entry.elem {
+		s.hand = s.hand.Prev()
+	}
+	s.order.Remove(entry.elem)
 }
 
-func (c *Cache) Close() {
-	c.cancel()
+// evict removes one entry according to the shard's eviction policy and
+// reports it so the caller can fire an OnEviction callback once unlocked.
+// Callers must hold s's write lock.
+func (s *shard[K, V]) evict() (key K, value V, evicted bool) {
+	switch s.policy {
+	case PolicySIEVE:
+		return s.evictSIEVE()
+	case PolicyLFU:
+		return s.evictLFU()
+	default: // PolicyLRU
+		return s.evictLRU()
+	}
+}
+
+// evictLRU removes the least-recently-used entry: the tail of order.
+func (s *shard[K, V]) evictLRU() (key K, value V, evicted bool) {
+	victim := s.order.Back()
+	if victim == nil {
+		return key, value, false
+	}
+	key = victim.Value.(K)
+	entry := s.items[key]
+	s.order.Remove(victim)
+	delete(s.items, key)
+	atomic.AddInt64(&s.evicted, 1)
+	return key, entry.Value, true
+}
+
+// evictSIEVE sweeps from the hand towards the head of order, clearing
+// visited bits until it finds an unvisited entry to evict. The hand is left
+// just before the evicted node so the next sweep resumes from there.
+func (s *shard[K, V]) evictSIEVE() (key K, value V, evicted bool) {
+	node := s.hand
+	if node == nil {
+		node = s.order.Back()
+	}
+
+	for node != nil {
+		k := node.Value.(K)
+		entry := s.items[k]
+		if atomic.LoadInt32(entry.visited) == 0 {
+			s.hand = node.Prev()
+			s.order.Remove(node)
+			delete(s.items, k)
+			atomic.AddInt64(&s.evicted, 1)
+			return k, entry.Value, true
+		}
+		atomic.StoreInt32(entry.visited, 0)
+		node = node.Prev()
+	}
+
+	// Wrapped around without finding an unvisited node; evict the tail,
+	// leaving the hand just before it so it never points at a removed node.
+	if back := s.order.Back(); back != nil {
+		s.hand = back.Prev()
+	}
+	return s.evictLRU()
+}
+
+// lfuInsert places a newly-Set key into the freq-1 bucket, reusing the
+// front bucket of freqList if it's already at frequency 1 or creating one
+// otherwise. A fresh key always starts at the lowest frequency, so freq-1
+// is always the front of an ascending-ordered freqList. Callers must hold
+// s's write lock.
+func (s *shard[K, V]) lfuInsert(key K, entry *CacheEntry[V]) {
+	front := s.freqList.Front()
+	if front == nil || front.Value.(*freqBucket[K]).freq != 1 {
+		front = s.freqList.PushFront(&freqBucket[K]{freq: 1, keys: list.New()})
+	}
+	bucket := front.Value.(*freqBucket[K])
+	entry.freqElem = front
+	entry.lfuElem = bucket.keys.PushFront(key)
+}
+
+// lfuTouch bumps entry's hit count by one, moving key from its current
+// frequency bucket to the next. Buckets are created lazily and freqList
+// stays ordered by ascending frequency because a hit only ever needs the
+// bucket immediately following the current one: its frequency either is
+// already current+1, or a new bucket is spliced in right there. A bucket
+// left empty by the move is dropped. Callers must hold s's write lock.
+func (s *shard[K, V]) lfuTouch(key K, entry *CacheEntry[V]) {
+	oldElem := entry.freqElem
+	oldBucket := oldElem.Value.(*freqBucket[K])
+	oldBucket.keys.Remove(entry.lfuElem)
+
+	newFreq := oldBucket.freq + 1
+	newElem := oldElem.Next()
+	if newElem == nil || newElem.Value.(*freqBucket[K]).freq != newFreq {
+		newElem = s.freqList.InsertAfter(&freqBucket[K]{freq: newFreq, keys: list.New()}, oldElem)
+	}
+
+	if oldBucket.keys.Len() == 0 {
+		s.freqList.Remove(oldElem)
+	}
+
+	newBucket := newElem.Value.(*freqBucket[K])
+	entry.freqElem = newElem
+	entry.lfuElem = newBucket.keys.PushFront(key)
+}
+
+// lfuRemove unlinks entry from its frequency bucket, dropping the bucket if
+// it's left empty. Callers must hold s's write lock.
+func (s *shard[K, V]) lfuRemove(entry CacheEntry[V]) {
+	bucket := entry.freqElem.Value.(*freqBucket[K])
+	bucket.keys.Remove(entry.lfuElem)
+	if bucket.keys.Len() == 0 {
+		s.freqList.Remove(entry.freqElem)
+	}
+}
+
+// evictLFU removes the least-recently-used key from the lowest-frequency
+// bucket: freqList's ascending order means that's always the back of the
+// front bucket's keys list.
+func (s *shard[K, V]) evictLFU() (key K, value V, evicted bool) {
+	front := s.freqList.Front()
+	if front == nil {
+		return key, value, false
+	}
+	bucket := front.Value.(*freqBucket[K])
+	victim := bucket.keys.Back()
+	if victim == nil {
+		return key, value, false
+	}
+
+	key = victim.Value.(K)
+	entry := s.items[key]
+	bucket.keys.Remove(victim)
+	if bucket.keys.Len() == 0 {
+		s.freqList.Remove(front)
+	}
+	delete(s.items, key)
+	atomic.AddInt64(&s.evicted, 1)
+	return key, entry.Value, true
+}
+
+func (c *Cache[K, V]) Close() {
+	for _, s := range c.shards {
+		s.cancel()
+	}
 }
@@ -0,0 +1,321 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRUEviction(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 2, Shards: 1, EvictionPolicy: PolicyLRU})
+	defer c.Close()
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be present")
+	}
+
+	// a was just touched, so b is the least-recently-used entry and should
+	// be the one evicted to make room for c.
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted, but it's still present")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected a to survive eviction")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("expected c to be present")
+	}
+}
+
+func TestGetOrLoadDeduplicatesConcurrentMisses(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 10})
+	defer c.Close()
+
+	var calls int64
+	loader := func() (int, error) {
+		atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return 42, nil
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			value, err := c.GetOrLoad("key", loader)
+			if err != nil {
+				t.Errorf("GetOrLoad: %v", err)
+			}
+			if value != 42 {
+				t.Errorf("GetOrLoad returned %d, want 42", value)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&calls); got != 1 {
+		t.Errorf("loader called %d times, want exactly 1", got)
+	}
+	if value, ok := c.Get("key"); !ok || value != 42 {
+		t.Errorf("Get(%q) = %d, %v, want 42, true", "key", value, ok)
+	}
+}
+
+func TestSIEVEEviction(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 2, Shards: 1, EvictionPolicy: PolicySIEVE})
+	defer c.Close()
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be present")
+	}
+
+	// a's visited bit is set, b's isn't: the hand should sweep past a
+	// (clearing its bit) and evict b, the unvisited entry.
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted, but it's still present")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected a to survive eviction")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("expected c to be present")
+	}
+}
+
+func TestLFUEviction(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 2, Shards: 1, EvictionPolicy: PolicyLFU})
+	defer c.Close()
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Get("a")
+	c.Get("b")
+
+	// a has 2 hits, b has 1: c should evict b, the least-frequently-used.
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted, but it's still present")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected a to survive eviction")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("expected c to be present")
+	}
+}
+
+func TestLFUEvictionBreaksTiesByRecency(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 2, Shards: 1, EvictionPolicy: PolicyLFU})
+	defer c.Close()
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Get("b")
+
+	// a and b are tied at 1 hit each; a was touched first, so it's the
+	// least-recently-used of the tied pair and should be the one evicted.
+	c.Set("c", 3)
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected a to be evicted as the older of the tied entries, but it's still present")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Error("expected b to survive eviction")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Error("expected c to be present")
+	}
+}
+
+func TestSlidingExpirationExtendsOnGet(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 10, TTL: 40 * time.Millisecond, SlidingExpiration: true})
+	defer c.Close()
+
+	c.Set("a", 1)
+	time.Sleep(25 * time.Millisecond)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to still be alive before its TTL elapsed")
+	}
+
+	// The Get above should have reset the expiration clock; without sliding
+	// expiration this second sleep would push a past its original deadline.
+	time.Sleep(25 * time.Millisecond)
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected Get to have slid a's expiration forward")
+	}
+}
+
+func TestNoExpirationPinsEntry(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 10, TTL: 10 * time.Millisecond})
+	defer c.Close()
+
+	c.SetWithTTL("a", 1, NoExpiration)
+	time.Sleep(30 * time.Millisecond)
+
+	if _, ok := c.Get("a"); !ok {
+		t.Error("expected NoExpiration entry to survive past the default TTL")
+	}
+}
+
+func TestExpiryHeapDiscardsStaleVersions(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 10, Shards: 1, TTL: time.Millisecond})
+	defer c.Close()
+
+	c.Set("a", 1)
+	// Overwriting bumps a's version and pushes a second, far-future expiry
+	// item without removing the first, now-stale one from the heap.
+	c.SetWithTTL("a", 2, time.Hour)
+
+	s := c.shardFor("a")
+	s.Lock()
+	keys, _ := s.collectExpiredLocked(time.Now().Add(2 * time.Millisecond))
+	s.Unlock()
+
+	if len(keys) != 0 {
+		t.Errorf("collectExpiredLocked reported %v as expired, want none", keys)
+	}
+	if value, ok := c.Get("a"); !ok || value != 2 {
+		t.Errorf("Get(%q) = %d, %v, want 2, true", "a", value, ok)
+	}
+}
+
+func TestExpiryHeapSurvivesDeleteThenRecreate(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 10, Shards: 1, TTL: time.Millisecond})
+	defer c.Close()
+
+	c.Set("a", 1)
+	time.Sleep(2 * time.Millisecond)
+	c.Delete("a")
+	// Recreating "a" must get a version that can't collide with the stale
+	// heap item left behind by the deleted entry above, even though this is
+	// a fresh CacheEntry whose version would otherwise start back at zero.
+	c.SetWithTTL("a", 2, time.Hour)
+
+	s := c.shardFor("a")
+	s.Lock()
+	keys, _ := s.collectExpiredLocked(time.Now())
+	s.Unlock()
+
+	if len(keys) != 0 {
+		t.Errorf("collectExpiredLocked reported %v as expired, want none", keys)
+	}
+	if value, ok := c.Get("a"); !ok || value != 2 {
+		t.Errorf("Get(%q) = %d, %v, want 2, true", "a", value, ok)
+	}
+}
+
+func TestOnInsertionFiresOnSet(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 10})
+	defer c.Close()
+
+	var got struct {
+		key   string
+		value int
+	}
+	unsubscribe := c.OnInsertion(func(key string, value int) {
+		got.key, got.value = key, value
+	})
+	defer unsubscribe()
+
+	c.Set("a", 1)
+
+	if got.key != "a" || got.value != 1 {
+		t.Errorf("OnInsertion fired with (%q, %d), want (\"a\", 1)", got.key, got.value)
+	}
+}
+
+func TestOnEvictionReasons(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 1, Shards: 1, EvictionPolicy: PolicyLRU})
+	defer c.Close()
+
+	var mu sync.Mutex
+	var reasons []EvictionReason
+	unsubscribe := c.OnEviction(func(key string, value int, reason EvictionReason) {
+		mu.Lock()
+		reasons = append(reasons, reason)
+		mu.Unlock()
+	})
+	defer unsubscribe()
+
+	c.Set("a", 1)
+	c.Set("a", 2) // overwrite: ReasonReplaced
+	c.Set("b", 3) // over MaxSize: evicts "a", ReasonCapacityReached
+	c.Delete("b") // ReasonDeleted
+
+	mu.Lock()
+	got := append([]EvictionReason(nil), reasons...)
+	mu.Unlock()
+
+	want := []EvictionReason{ReasonReplaced, ReasonCapacityReached, ReasonDeleted}
+	if len(got) != len(want) {
+		t.Fatalf("OnEviction fired %v, want %v", got, want)
+	}
+	for i, reason := range want {
+		if got[i] != reason {
+			t.Errorf("reason[%d] = %v, want %v", i, got[i], reason)
+		}
+	}
+}
+
+func TestOnEvictionFiresOnExpiry(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 10, Shards: 1, TTL: time.Millisecond, CleanupPeriod: 5 * time.Millisecond})
+	defer c.Close()
+
+	fired := make(chan EvictionReason, 1)
+	unsubscribe := c.OnEviction(func(key string, value int, reason EvictionReason) {
+		fired <- reason
+	})
+	defer unsubscribe()
+
+	c.Set("a", 1)
+
+	select {
+	case reason := <-fired:
+		if reason != ReasonExpired {
+			t.Errorf("OnEviction fired with reason %v, want ReasonExpired", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnEviction never fired for the expired entry")
+	}
+}
+
+func TestOnEvictionCanCallBackIntoCacheWithoutDeadlock(t *testing.T) {
+	c := New[string, int](Config{MaxSize: 1, Shards: 1})
+	defer c.Close()
+
+	done := make(chan struct{})
+	unsubscribe := c.OnEviction(func(key string, value int, reason EvictionReason) {
+		// If fireEviction ran under the shard lock, this Set would deadlock
+		// against the lock its own caller is still holding.
+		c.Set("from-callback", value)
+		close(done)
+	})
+	defer unsubscribe()
+
+	c.Set("a", 1)
+	c.Delete("a")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("OnEviction callback never completed, likely deadlocked calling back into the cache")
+	}
+
+	if value, ok := c.Get("from-callback"); !ok || value != 1 {
+		t.Errorf("Get(%q) = %d, %v, want 1, true", "from-callback", value, ok)
+	}
+}
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheKey is a convenience key type for AnyCache, the non-generic wrapper
+// kept for callers that haven't adopted Cache[K, V] yet.
+type CacheKey string
+
+// NoExpiration, passed to SetWithTTL, pins an entry so it never expires and
+// is never visited by the cleanup loop.
+const NoExpiration time.Duration = -1
+
+// CacheEntry holds a single cached value along with its expiration time.
+type CacheEntry[V any] struct {
+	Value      V
+	Expiration time.Time
+
+	// elem threads this entry through its shard's eviction list. Its
+	// list.Element.Value is the entry's key, so the list can be walked
+	// without holding a separate reference back into the map. Unused by
+	// PolicyLFU, which threads entries through freqElem/lfuElem instead.
+	elem *list.Element
+	// visited is the SIEVE "visited" bit, unused by other policies. It's a
+	// pointer so Get can flip it with an atomic store instead of rewriting
+	// the map entry, letting SIEVE reads run under the shard's RLock.
+	visited *int32
+
+	// freqElem is this entry's frequency bucket: an element of the shard's
+	// freqList whose Value is a *freqBucket[K]. PolicyLFU only.
+	freqElem *list.Element
+	// lfuElem is this entry's position within freqElem's key list, used to
+	// break ties between same-frequency entries by recency. PolicyLFU only.
+	lfuElem *list.Element
+
+	// ttl is the duration applied to this entry, remembered so sliding
+	// expiration can recompute Expiration on Get. NoExpiration means the
+	// entry is pinned.
+	ttl time.Duration
+	// version is stamped from the shard's versionSeq every time Expiration
+	// changes, so stale entries in a shard's expiry heap can be recognized
+	// and discarded lazily. It comes from a shard-wide counter rather than
+	// starting over at zero for each new entry, so a key that's deleted and
+	// re-Set can't collide with a heap item left over from before the delete.
+	version int64
+}
+
+// expired reports whether the entry's TTL has elapsed as of now. A zero ttl
+// means the entry has no expiration, the same as NoExpiration, so a Cache
+// built with a zero-value Config.TTL doesn't expire entries the instant
+// they're read.
+func (e CacheEntry[V]) expired(now time.Time) bool {
+	return e.ttl > 0 && now.After(e.Expiration)
+}
+
+// EvictionPolicy selects how a shard chooses a victim when it is full.
+type EvictionPolicy int
+
+const (
+	// PolicyLRU evicts the least-recently-used entry. O(1) eviction, with a
+	// list move on every Get.
+	PolicyLRU EvictionPolicy = iota
+	// PolicyLFU evicts the least-frequently-used entry, breaking ties by
+	// recency. O(1) eviction and O(1) Get, using the classic frequency-list
+	// structure (Shah, Mitra & Matani): entries are bucketed by hit count
+	// into freqList, a list of buckets ordered by ascending frequency, so
+	// eviction just pops the tail of the lowest bucket and a hit just moves
+	// an entry one bucket forward.
+	PolicyLFU
+	// PolicySIEVE evicts using the SIEVE algorithm: a single visited bit per
+	// entry and a hand that sweeps the list, giving near-LRU hit ratios
+	// without moving entries on every Get.
+	PolicySIEVE
+)
+
+// Config controls how a Cache is constructed.
+type Config struct {
+	// MaxSize is the maximum number of entries held by each shard.
+	MaxSize int
+	// TTL is the default time-to-live applied to entries on Set. Use
+	// SetWithTTL for a per-entry override. Zero, like NoExpiration, means
+	// entries set through Set never expire.
+	TTL time.Duration
+	// CleanupPeriod is how often expired entries are swept from each shard.
+	// Defaults to one minute when zero or negative.
+	CleanupPeriod time.Duration
+	// Shards is the number of independent shards backing the cache. Must be
+	// a power of two; defaults to 256 when zero or not a power of two.
+	Shards int
+	// EvictionPolicy selects the victim-selection strategy used once a shard
+	// reaches MaxSize. Defaults to PolicyLRU.
+	EvictionPolicy EvictionPolicy
+	// SlidingExpiration, when true, resets an entry's expiration to
+	// now+ttl on every successful Get instead of leaving it fixed from
+	// insertion. Useful for session-like caches where activity should keep
+	// entries alive. Has no effect on NoExpiration entries.
+	SlidingExpiration bool
+}
+
+// CacheStats reports point-in-time counters for a Cache, aggregated across
+// all of its shards.
+type CacheStats struct {
+	Size    int
+	Hits    int64
+	Misses  int64
+	Evicted int64
+}
+
+// EvictionReason describes why an entry left the cache, passed to OnEviction
+// subscribers.
+type EvictionReason int
+
+const (
+	// ReasonDeleted means the entry was removed by an explicit Delete call.
+	ReasonDeleted EvictionReason = iota
+	// ReasonExpired means the cleanup loop found the entry past its TTL.
+	ReasonExpired
+	// ReasonCapacityReached means the entry was evicted to make room for a
+	// new one under the shard's eviction policy.
+	ReasonCapacityReached
+	// ReasonReplaced means a Set call overwrote an existing key.
+	ReasonReplaced
+)
+
+// Cache is a sharded, TTL-based in-memory cache, generic over its key and
+// value types. Keys are distributed across shards by hash so that
+// concurrent callers touching different keys rarely contend on the same
+// lock.
+type Cache[K comparable, V any] struct {
+	shards    []*shard[K, V]
+	shardMax  uint64
+	callbacks *callbackRegistry[K, V]
+}
+
+// callbackRegistry holds the OnEviction/OnInsertion subscribers for a Cache.
+// It is shared by every shard so that all of them report through the same
+// subscription set.
+type callbackRegistry[K comparable, V any] struct {
+	mu          sync.Mutex
+	nextID      uint64
+	onEviction  map[uint64]func(key K, value V, reason EvictionReason)
+	onInsertion map[uint64]func(key K, value V)
+}
+
+func newCallbackRegistry[K comparable, V any]() *callbackRegistry[K, V] {
+	return &callbackRegistry[K, V]{
+		onEviction:  make(map[uint64]func(key K, value V, reason EvictionReason)),
+		onInsertion: make(map[uint64]func(key K, value V)),
+	}
+}
+
+func (r *callbackRegistry[K, V]) addEviction(fn func(key K, value V, reason EvictionReason)) func() {
+	r.mu.Lock()
+	id := r.nextID
+	r.nextID++
+	r.onEviction[id] = fn
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		delete(r.onEviction, id)
+		r.mu.Unlock()
+	}
+}
+
+func (r *callbackRegistry[K, V]) addInsertion(fn func(key K, value V)) func() {
+	r.mu.Lock()
+	id := r.nextID
+	r.nextID++
+	r.onInsertion[id] = fn
+	r.mu.Unlock()
+
+	return func() {
+		r.mu.Lock()
+		delete(r.onInsertion, id)
+		r.mu.Unlock()
+	}
+}
+
+// fireEviction invokes every OnEviction subscriber. It must not be called
+// while holding a shard lock, since handlers may call back into the cache.
+func (r *callbackRegistry[K, V]) fireEviction(key K, value V, reason EvictionReason) {
+	r.mu.Lock()
+	handlers := make([]func(K, V, EvictionReason), 0, len(r.onEviction))
+	for _, fn := range r.onEviction {
+		handlers = append(handlers, fn)
+	}
+	r.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(key, value, reason)
+	}
+}
+
+// fireInsertion invokes every OnInsertion subscriber. It must not be called
+// while holding a shard lock, since handlers may call back into the cache.
+func (r *callbackRegistry[K, V]) fireInsertion(key K, value V) {
+	r.mu.Lock()
+	handlers := make([]func(K, V), 0, len(r.onInsertion))
+	for _, fn := range r.onInsertion {
+		handlers = append(handlers, fn)
+	}
+	r.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(key, value)
+	}
+}
+
+// AnyCache is a convenience alias for the pre-generics Cache API: string
+// keys and interface{} values. New code should prefer Cache[K, V] with
+// concrete types; AnyCache exists so callers that can't migrate yet, or
+// that genuinely need a heterogeneous value type, aren't left behind.
+type AnyCache = Cache[CacheKey, interface{}]
+
+// shard is one independently-locked partition of the cache.
+type shard[K comparable, V any] struct {
+	sync.RWMutex
+	items   map[K]CacheEntry[V]
+	maxSize int
+	ttl     time.Duration
+	sliding bool
+
+	policy EvictionPolicy
+	order  *list.List    // eviction order, shared by PolicyLRU and PolicySIEVE
+	hand   *list.Element // SIEVE hand; unused by other policies
+
+	freqList *list.List // PolicyLFU only: *freqBucket[K] elements, ascending by frequency
+
+	expiry     expiryHeap[K] // min-heap of pending expirations, lazily pruned
+	versionSeq int64         // monotonic source for CacheEntry.version
+
+	// loadGroup deduplicates concurrent GetOrLoad/Refresh calls for a key
+	// landing on this shard, so a cache miss under load triggers exactly one
+	// loader call. It's per-shard, like everything else here, so a stampede
+	// on one shard's keys can't serialize callers working on another.
+	loadGroup singleflight.Group
+
+	hits    int64
+	misses  int64
+	evicted int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// freqBucket groups every key currently at the same LFU hit count. A
+// shard's freqList holds one of these per distinct frequency present,
+// ordered ascending, so the eviction victim is always the back of the
+// front bucket's keys list and a hit only ever has to look at its entry's
+// bucket and the next one.
+type freqBucket[K comparable] struct {
+	freq int
+	keys *list.List // keys at this frequency; front = most recently used
+}
+
+// expiryItem is one pending-expiration entry in a shard's expiry heap. It is
+// only a hint: by the time it reaches the front of the heap, the entry it
+// names may have been deleted or its expiration pushed out, so the consumer
+// must check version against the live CacheEntry before acting on it.
+type expiryItem[K comparable] struct {
+	key        K
+	expiration time.Time
+	version    int64
+}
+
+// expiryHeap is a container/heap.Interface ordering expiryItems by
+// expiration so the cleanup loop can pop due entries in O(log n) instead of
+// scanning every entry in the shard.
+type expiryHeap[K comparable] []expiryItem[K]
+
+func (h expiryHeap[K]) Len() int            { return len(h) }
+func (h expiryHeap[K]) Less(i, j int) bool  { return h[i].expiration.Before(h[j].expiration) }
+func (h expiryHeap[K]) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap[K]) Push(x interface{}) { *h = append(*h, x.(expiryItem[K])) }
+func (h *expiryHeap[K]) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}